@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ta2min/smart-meter-client/pkg/echonet"
+)
+
+// newTestBP35A1 wires up a BP35A1 around a FakeTransport, the same way
+// NewBP35A1 wires one around a SerialTransport, so a test can script the
+// module's side of an exchange and drive the real command methods.
+func newTestBP35A1(transport *FakeTransport) *BP35A1 {
+	bp := &BP35A1{
+		Transport:   transport,
+		DebugWriter: io.Discard,
+		RouteB_ID:   "00112233445566778899AABBCCDDEEFF",
+		RouteB_PW:   "password",
+	}
+	bp.events = newEventLoop(bp)
+	go bp.events.run()
+	return bp
+}
+
+func TestFetchVersion(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines("SKVER", "EVER 1.2.10", "OK")
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	version, err := bp.FetchVersion()
+	if err != nil {
+		t.Fatalf("FetchVersion: %v", err)
+	}
+	if version != "EVER 1.2.10" {
+		t.Fatalf("version = %q, want %q", version, "EVER 1.2.10")
+	}
+}
+
+func TestRouteBLogin(t *testing.T) {
+	transport := NewFakeTransport()
+	// Two separate batches: RouteBLogin issues two commands, each with its
+	// own Subscribe/unsubscribe window, so each needs its own batch
+	// released by its own Write (see FakeTransport's doc comment).
+	transport.QueueLines("SKSETRBID 00112233445566778899AABBCCDDEEFF ", "OK")
+	transport.QueueLines("SKSETPWD  C password ", "OK")
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	if err := bp.RouteBLogin(); err != nil {
+		t.Fatalf("RouteBLogin: %v", err)
+	}
+	if len(transport.Sent) != 2 {
+		t.Fatalf("sent %d commands, want 2", len(transport.Sent))
+	}
+}
+
+func TestRouteBLoginFailFastsOnFAIL(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKSETRBID 00112233445566778899AABBCCDDEEFF ",
+		"FAIL ER10",
+	)
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	if err := bp.RouteBLogin(); err == nil {
+		t.Fatal("RouteBLogin: want error on FAIL ER10, got nil")
+	}
+}
+
+func TestSetNetWrokInfo(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKSCAN 2 FFFFFFFF 5",
+		"OK",
+		"EPANDESC",
+		"  Channel:3B",
+		"  Channel Page:09",
+		"  Pan ID:8888",
+		"  Addr:00112233445566778899AABBCCDDEEFF",
+		"  LQI:E1",
+		"  PairID:12345678",
+		"EVENT 22",
+	)
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	if err := bp.SetNetWrokInfo(); err != nil {
+		t.Fatalf("SetNetWrokInfo: %v", err)
+	}
+	want := NetWrokInfo{
+		Channel:     "3B",
+		ChannelPage: "09",
+		PanID:       "8888",
+		Addr:        "00112233445566778899AABBCCDDEEFF",
+		LQI:         "E1",
+		PairID:      "12345678",
+	}
+	if bp.NetWrokInfo != want {
+		t.Fatalf("NetWrokInfo = %+v, want %+v", bp.NetWrokInfo, want)
+	}
+}
+
+func TestConBRoute(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKJOIN FE80:0000:0000:0000:0011:2233:4455:6677",
+		"OK",
+		"EVENT 25",
+		"PANA initialized",
+	)
+	bp := newTestBP35A1(transport)
+	bp.IPv6Addr = "FE80:0000:0000:0000:0011:2233:4455:6677"
+	defer bp.Close()
+
+	if err := bp.ConBRoute(); err != nil {
+		t.Fatalf("ConBRoute: %v", err)
+	}
+}
+
+func TestConBRoutePANAAuthFailed(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKJOIN FE80:0000:0000:0000:0011:2233:4455:6677",
+		"OK",
+		"EVENT 24",
+	)
+	bp := newTestBP35A1(transport)
+	bp.IPv6Addr = "FE80:0000:0000:0000:0011:2233:4455:6677"
+	defer bp.Close()
+
+	if err := bp.ConBRoute(); err == nil {
+		t.Fatal("ConBRoute: want error on EVENT 24, got nil")
+	}
+}
+
+func TestSetIPv6Addr(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKLL64 00112233445566778899AABBCCDDEEFF",
+		"FE80:0000:0000:0000:0011:2233:4455:6677",
+	)
+	bp := newTestBP35A1(transport)
+	bp.NetWrokInfo.Addr = "00112233445566778899AABBCCDDEEFF"
+	defer bp.Close()
+
+	if err := bp.SetIPv6Addr(); err != nil {
+		t.Fatalf("SetIPv6Addr: %v", err)
+	}
+	if bp.IPv6Addr != "FE80:0000:0000:0000:0011:2233:4455:6677" {
+		t.Fatalf("IPv6Addr = %q", bp.IPv6Addr)
+	}
+}
+
+func TestSmartMeterInstantaneousPower(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines(
+		"SKSENDTO 1 FE80:0000:0000:0000:0011:2233:4455:6677 0E1A 1 000E 1081000105FF010288016201E700",
+		"OK",
+		BuildERXUDP(1, "FE80:0000:0000:0000:0011:2233:4455:6677", "001D129012345678", "028801", "05FF01", echonet.ESVGetRes,
+			ERXUDPProperty{EPC: 0xE7, EDT: []byte{0x00, 0x00, 0x03, 0xE8}}),
+	)
+	bp := newTestBP35A1(transport)
+	bp.IPv6Addr = "FE80:0000:0000:0000:0011:2233:4455:6677"
+	defer bp.Close()
+
+	power, err := bp.SmartMeter().InstantaneousPower()
+	if err != nil {
+		t.Fatalf("InstantaneousPower: %v", err)
+	}
+	if power != 1000 {
+		t.Fatalf("power = %d, want 1000", power)
+	}
+}
+
+// TestSmartMeterConcurrentRequestsDemultiplexByTID drives two SmartMeter
+// accessors concurrently through a single FakeTransport and checks each
+// gets its own ERXUDP back rather than the other's - the scenario
+// echonetSender.SendFrame's TID demultiplexing exists for, and the one
+// that would have broken under the old broadcast-everything-to-everyone
+// handshake.
+func TestSmartMeterConcurrentRequestsDemultiplexByTID(t *testing.T) {
+	transport := NewFakeTransport()
+	// BP35A1.nextTID hands out 1 and 2, one to each of the two SendFrame
+	// calls below, in whichever order they happen to run, so each frame
+	// answers both EPCs: frame 1 with 1000 W and (10, -10) A, frame 2 with
+	// 2000 W and (20, -20) A.
+	transport.QueueLines(
+		"SKSENDTO 1 ... 1",
+		"OK",
+		BuildERXUDP(1, "FE80:0000:0000:0000:0011:2233:4455:6677", "001D129012345678", "028801", "05FF01", echonet.ESVGetRes,
+			ERXUDPProperty{EPC: 0xE7, EDT: []byte{0x00, 0x00, 0x03, 0xE8}},
+			ERXUDPProperty{EPC: 0xE8, EDT: []byte{0x00, 0x64, 0xFF, 0x9C}}),
+	)
+	transport.QueueLines(
+		"SKSENDTO 1 ... 2",
+		"OK",
+		BuildERXUDP(2, "FE80:0000:0000:0000:0011:2233:4455:6677", "001D129012345678", "028801", "05FF01", echonet.ESVGetRes,
+			ERXUDPProperty{EPC: 0xE7, EDT: []byte{0x00, 0x00, 0x07, 0xD0}},
+			ERXUDPProperty{EPC: 0xE8, EDT: []byte{0x00, 0xC8, 0xFF, 0x38}}),
+	)
+	bp := newTestBP35A1(transport)
+	bp.IPv6Addr = "FE80:0000:0000:0000:0011:2233:4455:6677"
+	defer bp.Close()
+
+	var power int
+	var r, tAmps float64
+	var powerErr, currentErr error
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		power, powerErr = bp.SmartMeter().InstantaneousPower()
+	}()
+	go func() {
+		defer wg.Done()
+		r, tAmps, currentErr = bp.SmartMeter().InstantaneousCurrent()
+	}()
+	wg.Wait()
+
+	if powerErr != nil {
+		t.Fatalf("InstantaneousPower: %v", powerErr)
+	}
+	if currentErr != nil {
+		t.Fatalf("InstantaneousCurrent: %v", currentErr)
+	}
+	// Whichever request drew TID 1 must have read frame 1 and the other
+	// frame 2; both reading the same frame pairs 1000 W with 10 A or
+	// 2000 W with 20 A.
+	if power != 1000 && power != 2000 {
+		t.Fatalf("power = %d, want 1000 or 2000", power)
+	}
+	wantAmps := 20.0
+	if power == 2000 {
+		wantAmps = 10
+	}
+	if r != wantAmps || tAmps != -wantAmps {
+		t.Fatalf("current = (%v, %v), want (%v, %v) alongside power %d", r, tAmps, wantAmps, -wantAmps, power)
+	}
+}
+
+// TestConcurrentCommandsDoNotCrossContaminate reproduces the race a FAIL
+// meant for one in-flight command used to be able to abort another: two
+// plain commands run concurrently, one queued to fail and one to succeed.
+// BeginCmd serializes them, so each Write is paired 1:1 with the batch it
+// releases and neither sees the other's echo/OK/FAIL.
+func TestConcurrentCommandsDoNotCrossContaminate(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.QueueLines("SKSETRBID bad ", "FAIL ER10")
+	transport.QueueLines("SKSETRBID good ", "OK")
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = bp.sendAndAwaitOK("SKSETRBID bad \r\n")
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = bp.sendAndAwaitOK("SKSETRBID good \r\n")
+	}()
+	wg.Wait()
+
+	failed, succeeded := 0, 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+	if failed != 1 || succeeded != 1 {
+		t.Fatalf("errs = %v, want exactly one FAIL and one success", errs)
+	}
+}
+
+func TestAwaitEventPropagatesTransportError(t *testing.T) {
+	transport := NewFakeTransport()
+	transport.SetReadTimeout(20 * time.Millisecond)
+	bp := newTestBP35A1(transport)
+	defer bp.Close()
+
+	sub, unsubscribe := bp.Subscribe()
+	defer unsubscribe()
+
+	// With nothing ever queued, the event loop's ReadLine eventually times
+	// out and broadcasts that error to every subscriber before exiting -
+	// well before awaitEvent's own (much longer) deadline would fire.
+	_, err := bp.awaitEvent(sub, time.Second, func(any) bool { return false })
+	if err != ErrReadTimeout {
+		t.Fatalf("err = %v, want ErrReadTimeout", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ta2min/smart-meter-client/pkg/echonet"
+)
+
+// echonetSender adapts BP35A1's SKSENDTO/ERXUDP request-response cycle to
+// echonet.RequestSender, so SmartMeter's generated accessors don't need to
+// know anything about SKSTACK-IP. Requests are demultiplexed by TID, so
+// several can be in flight concurrently and unrelated asynchronous events
+// (EVENT 29 session expiry, unsolicited ERXUDP pushes) no longer derail
+// them. The echo/OK/send-failure handshake that precedes the ERXUDP wait
+// has no TID of its own, so that part is instead serialized via BeginCmd,
+// released as soon as it's done so concurrent SendFrame calls only ever
+// contend on the brief ack, not on each other's (much longer) ERXUDP wait.
+type echonetSender struct {
+	bp *BP35A1
+}
+
+func (s *echonetSender) SendFrame(req echonet.Frame) (echonet.Frame, error) {
+	bp := s.bp
+	req.TID = bp.nextTID()
+
+	sub, unsubscribe := bp.Subscribe()
+	defer unsubscribe()
+
+	payload := req.Marshal()
+	command := append([]byte(fmt.Sprintf("SKSENDTO 1 %s 0E1A 1 %04X ", bp.IPv6Addr, len(payload))), payload...)
+	bp.debugPrint(hex.EncodeToString(command))
+
+	cmd, end := bp.BeginCmd()
+	bp.Write(command)
+	err := bp.awaitEchoAndOKOrSendFailure(cmd, 5*time.Second)
+	end()
+	if err != nil {
+		return echonet.Frame{}, err
+	}
+
+	var res echonet.Frame
+	_, err = bp.awaitEvent(sub, 10*time.Second, func(ev any) bool {
+		udp, ok := ev.(ERXUDP)
+		if !ok {
+			return false
+		}
+		frame, err := echonet.Unmarshal(udp.Data)
+		if err != nil || frame.TID != req.TID {
+			return false
+		}
+		res = frame
+		return true
+	})
+	if err != nil {
+		return echonet.Frame{}, err
+	}
+
+	return res, nil
+}
+
+// awaitEchoAndOKOrSendFailure is awaitEchoAndOK plus the one extra outcome
+// SKSENDTO has that a plain SKxxx command doesn't: EVENT 21 reports that
+// the UDP datagram itself couldn't be sent.
+func (bp *BP35A1) awaitEchoAndOKOrSendFailure(sub <-chan any, timeout time.Duration) error {
+	seenEcho := false
+	ev, err := bp.awaitEvent(sub, timeout, func(ev any) bool {
+		switch v := ev.(type) {
+		case Line:
+			seenEcho = true
+			return false
+		case EventNum:
+			return v.Num == 0x21
+		case OK:
+			return seenEcho
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if _, failed := ev.(EventNum); failed {
+		return ErrUnexpectedString
+	}
+	return nil
+}
+
+// SmartMeter returns an echonet.SmartMeter client talking to the meter this
+// BP35A1 is joined to.
+func (bp *BP35A1) SmartMeter() *echonet.SmartMeter {
+	return echonet.NewSmartMeter(&echonetSender{bp: bp})
+}
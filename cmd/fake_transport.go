@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeTransport is an in-memory Transport for exercising BP35A1's request
+// and response methods without real hardware. A test scripts the lines it
+// expects the module to emit for a given command (echo-backs, "OK", EVENT
+// lines, EPANDESC blocks, ERXUDP notifications, ...) with QueueLine or
+// QueueLines, and records every command BP35A1 writes in Sent for later
+// assertions.
+//
+// Each QueueLines call stages one batch of lines, released to ReadLine only
+// once the next Write happens - not the moment it's called. A real module
+// never replies until it has actually received a command, and BP35A1's
+// command methods rely on that: they Subscribe before writing, so on real
+// hardware the echo and OK for one command can never be confused with
+// those of the next. Releasing batches eagerly would let this fake race
+// ahead of a multi-command method (e.g. RouteBLogin's two SK commands) and
+// silently drop a later batch into an unsubscribed window.
+//
+// ReadLine blocks until a line is released or the read timeout elapses,
+// just like a real serial read, rather than failing the instant nothing is
+// queued yet - the event loop treats any ReadLine error as fatal and stops,
+// so an instant "nothing queued" error would kill it before the first
+// command was even sent. Modeled on wireguard-go's bindtest package for
+// conn.Bind.
+type FakeTransport struct {
+	Sent   [][]byte
+	Closed bool
+
+	mu      sync.Mutex
+	pending [][]string // batches awaiting the Write that releases them
+	queue   []string   // released lines, ready for ReadLine
+	timeout time.Duration
+	notify  chan struct{}
+}
+
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{timeout: 3 * time.Second, notify: make(chan struct{}, 1)}
+}
+
+// QueueLine stages a single-line batch; see QueueLines.
+func (t *FakeTransport) QueueLine(line string) {
+	t.QueueLines(line)
+}
+
+// QueueLines stages a batch of lines, in order, to be released by the next
+// call to Write.
+func (t *FakeTransport) QueueLines(lines ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, lines)
+}
+
+// QueueAsync makes lines available to ReadLine immediately, without
+// waiting for a Write - for modeling a genuinely unsolicited push (EVENT
+// 29 session expiry, EVENT 32/33 ARIB restart, a spontaneous ERXUDP) that
+// doesn't follow any command the test wrote.
+func (t *FakeTransport) QueueAsync(lines ...string) {
+	t.mu.Lock()
+	t.queue = append(t.queue, lines...)
+	t.mu.Unlock()
+	t.wake()
+}
+
+func (t *FakeTransport) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.Sent = append(t.Sent, append([]byte(nil), p...))
+	if len(t.pending) > 0 {
+		t.queue = append(t.queue, t.pending[0]...)
+		t.pending = t.pending[1:]
+	}
+	t.mu.Unlock()
+	t.wake()
+	return len(p), nil
+}
+
+func (t *FakeTransport) ReadLine() ([]byte, error) {
+	for {
+		t.mu.Lock()
+		if t.Closed {
+			t.mu.Unlock()
+			return nil, ErrReadTimeout
+		}
+		if len(t.queue) > 0 {
+			line := t.queue[0]
+			t.queue = t.queue[1:]
+			t.mu.Unlock()
+			return []byte(line), nil
+		}
+		timeout := t.timeout
+		t.mu.Unlock()
+
+		select {
+		case <-t.notify:
+		case <-time.After(timeout):
+			return nil, ErrReadTimeout
+		}
+	}
+}
+
+func (t *FakeTransport) wake() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (t *FakeTransport) ResetInputBuffer() error  { return nil }
+func (t *FakeTransport) ResetOutputBuffer() error { return nil }
+
+func (t *FakeTransport) SetReadTimeout(d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timeout = d
+	return nil
+}
+
+func (t *FakeTransport) Close() error {
+	t.mu.Lock()
+	t.Closed = true
+	t.mu.Unlock()
+	t.wake()
+	return nil
+}
+
+// ERXUDPProperty is one EPC/EDT pair carried by a fake ERXUDP notification.
+type ERXUDPProperty struct {
+	EPC byte
+	EDT []byte
+}
+
+// BuildERXUDP renders a SKSTACK-IP ERXUDP line carrying an ECHONET Lite
+// response frame for the given TID, SEOJ/DEOJ/ESV and properties, so tests
+// can queue canned meter responses without hand-assembling hex. tid must
+// match the TID the BP35A1 under test actually stamped on its request
+// (SendFrame demultiplexes ERXUDP notifications by TID), which for a fresh
+// BP35A1 is 1 for its first request, 2 for its second, and so on.
+func BuildERXUDP(tid uint16, sender, senderLLA string, seoj, deoj string, esv byte, props ...ERXUDPProperty) string {
+	frame := fmt.Sprintf("1081%04X%s%s%02X%02X", tid, seoj, deoj, esv, len(props))
+	for _, p := range props {
+		frame += fmt.Sprintf("%02X%02X%s", p.EPC, len(p.EDT), hex.EncodeToString(p.EDT))
+	}
+	frame = strings.ToUpper(frame)
+
+	return fmt.Sprintf("ERXUDP %s %s 0E1A 0E1A %s 1 %04X %s", sender, sender, senderLLA, len(frame)/2, frame)
+}
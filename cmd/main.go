@@ -1,19 +1,17 @@
 package main
 
 import (
-	"bufio"
-	"encoding/hex"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"go.bug.st/serial"
+	"github.com/ta2min/smart-meter-client/pkg/sink"
 )
 
 var ErrUnexpectedString = errors.New("unexpected string")
@@ -29,20 +27,17 @@ type NetWrokInfo struct {
 	PairID      string
 }
 
-func (n NetWrokInfo) isValid() bool {
-	return n.Channel != "" && n.ChannelPage != "" && n.PanID != "" && n.Addr != "" && n.LQI != "" && n.PairID != ""
-}
-
 type BP35A1 struct {
-	serial.Port
-	BufReader   *bufio.Reader
+	Transport   Transport
 	NetWrokInfo NetWrokInfo
 	Debug       bool
 	DebugWriter io.Writer
 	RouteB_ID   string
 	RouteB_PW   string
 	IPv6Addr    string
-	used        sync.Mutex
+
+	events  *eventLoop
+	lastTID uint32
 }
 
 func (bp *BP35A1) debugPrint(a ...any) {
@@ -51,126 +46,152 @@ func (bp *BP35A1) debugPrint(a ...any) {
 	}
 }
 
-func (bp *BP35A1) ReadLine() ([]byte, error) {
-	var data []byte
-	buf := make([]byte, 1)
+// Subscribe returns every event the background reader broadcasts from now
+// on (ERXUDP notifications, EPANDESC blocks, read errors, and EVENT lines
+// that arrive while no command holds BeginCmd), plus an unsubscribe func
+// the caller must call once done. Use it to observe asynchronous notifications
+// - session drops, spontaneous meter pushes - without racing an in-flight
+// request's own Subscribe.
+func (bp *BP35A1) Subscribe() (<-chan any, func()) {
+	return bp.events.Subscribe()
+}
 
-	for {
-		_, err := bp.Read(buf)
-		if err != nil {
-			return nil, err
-		}
+// BeginCmd reserves exclusive right to the module's next echo-back, OK and
+// FAIL, until the returned end func is called. Use it around the write and
+// the matching of those three event types; release it (end) before any
+// further wait for an asynchronous, self-identifying event (EVENT NN,
+// ERXUDP by TID) so unrelated commands aren't blocked behind it.
+func (bp *BP35A1) BeginCmd() (<-chan any, func()) {
+	return bp.events.BeginCmd()
+}
 
-		data = append(data, buf...)
-		// bp.debugPrint("data len: ", len(data), "buf: ", string(buf), "buf raw: ", buf)
+// nextTID returns the next transaction ID to stamp on an outgoing ECHONET
+// Lite frame, so its response can be demultiplexed from unrelated traffic.
+func (bp *BP35A1) nextTID() uint16 {
+	return uint16(atomic.AddUint32(&bp.lastTID, 1))
+}
 
-		if buf[0] == 0 {
-			return []byte(""), ErrReadTimeout
-		}
+// Write, ReadLine and the buffer/timeout controls below simply forward to
+// bp.Transport, so the command methods further down don't need to change
+// whether they're talking to a real serial port or a FakeTransport in tests.
 
-		if len(data) >= 2 && data[len(data)-2] == '\r' && data[len(data)-1] == '\n' {
-			// "\r\n"が到着したら読み込み終了
-			break
-		}
-	}
+func (bp *BP35A1) Write(p []byte) (int, error) {
+	return bp.Transport.Write(p)
+}
 
-	return data[:len(data)-2], nil
+func (bp *BP35A1) ReadLine() ([]byte, error) {
+	return bp.Transport.ReadLine()
 }
 
-func (bp *BP35A1) FetchVersion() (string, error) {
-	bp.Write([]byte("SKVER\r\n"))
+func (bp *BP35A1) ResetInputBuffer() error {
+	return bp.Transport.ResetInputBuffer()
+}
 
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return "", err
-	}
-	bp.debugPrint(string(echoBack))
+func (bp *BP35A1) ResetOutputBuffer() error {
+	return bp.Transport.ResetOutputBuffer()
+}
 
-	version, err := bp.ReadLine()
-	if err != nil {
-		return "", err
-	}
-	bp.debugPrint(string(version))
+func (bp *BP35A1) SetReadTimeout(d time.Duration) error {
+	return bp.Transport.SetReadTimeout(d)
+}
 
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return "", err
-	}
-	bp.debugPrint(string(ok))
+func (bp *BP35A1) Close() error {
+	return bp.Transport.Close()
+}
 
-	return string(version), nil
+// awaitEchoAndOK consumes the command echo-back the module always sends
+// first, then waits for the OK that follows it.
+func (bp *BP35A1) awaitEchoAndOK(sub <-chan any, timeout time.Duration) error {
+	seenEcho := false
+	_, err := bp.awaitEvent(sub, timeout, func(ev any) bool {
+		switch ev.(type) {
+		case Line:
+			seenEcho = true
+			return false
+		case OK:
+			return seenEcho
+		}
+		return false
+	})
+	return err
 }
 
-func (bp *BP35A1) RouteBLogin() error {
-	bp.Write([]byte(fmt.Sprintf("SKSETRBID %s \r\n", bp.RouteB_ID)))
+// sendAndAwaitOK writes cmd and waits for its echo-back and OK, for the
+// simple SKSTACK-IP commands that don't carry any other response.
+func (bp *BP35A1) sendAndAwaitOK(cmd string) error {
+	sub, end := bp.BeginCmd()
+	defer end()
 
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(echoBack))
+	bp.Write([]byte(cmd))
+	return bp.awaitEchoAndOK(sub, 3*time.Second)
+}
 
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(ok))
+func (bp *BP35A1) FetchVersion() (string, error) {
+	sub, end := bp.BeginCmd()
+	defer end()
 
-	bp.Write([]byte(fmt.Sprintf("SKSETPWD  C %s \r\n", bp.RouteB_PW)))
+	bp.Write([]byte("SKVER\r\n"))
 
-	echoBack, err = bp.ReadLine()
+	lines := 0
+	var version string
+	_, err := bp.awaitEvent(sub, 3*time.Second, func(ev any) bool {
+		switch v := ev.(type) {
+		case Line:
+			lines++
+			if lines == 2 {
+				version = v.Text
+			}
+			return false
+		case OK:
+			return lines >= 2
+		}
+		return false
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
-	bp.debugPrint(string(echoBack))
 
-	ok, err = bp.ReadLine()
-	if err != nil {
+	return version, nil
+}
+
+func (bp *BP35A1) RouteBLogin() error {
+	if err := bp.sendAndAwaitOK(fmt.Sprintf("SKSETRBID %s \r\n", bp.RouteB_ID)); err != nil {
 		return err
 	}
-	bp.debugPrint(string(ok))
-
-	return nil
+	return bp.sendAndAwaitOK(fmt.Sprintf("SKSETPWD  C %s \r\n", bp.RouteB_PW))
 }
 
 func (bp *BP35A1) SetNetWrokInfo() error {
-	var netWrokInfo NetWrokInfo
+	sub, unsubscribe := bp.Subscribe()
+	defer unsubscribe()
+
 	scanDuration := 5
 	for {
 		if scanDuration > 7 {
 			return fmt.Errorf("scan retry over error")
 		}
+
 		bp.Write([]byte(fmt.Sprintf("SKSCAN 2 FFFFFFFF %d\r\n", scanDuration)))
-		scanEnd := false
 
-		for !scanEnd {
-			res, err := bp.ReadLine()
-			if err != nil {
-				return err
-			}
-			if strings.HasPrefix(string(res), "EVENT 22") {
-				scanEnd = true
-			} else if strings.HasPrefix(string(res), "  ") {
-				cols := strings.Split(strings.TrimSpace(string(res)), ":")
-				bp.debugPrint(string(res))
-				switch cols[0] {
-				case "Channel":
-					netWrokInfo.Channel = cols[1]
-				case "Channel Page":
-					netWrokInfo.ChannelPage = cols[1]
-				case "Pan ID":
-					netWrokInfo.PanID = cols[1]
-				case "Addr":
-					netWrokInfo.Addr = cols[1]
-				case "LQI":
-					netWrokInfo.LQI = cols[1]
-				case "PairID":
-					netWrokInfo.PairID = cols[1]
-				}
+		var found NetWrokInfo
+		scanTimeout := time.Duration(scanDuration+5) * 10 * time.Second
+		_, err := bp.awaitEvent(sub, scanTimeout, func(ev any) bool {
+			switch v := ev.(type) {
+			case EPANDESC:
+				found = NetWrokInfo(v)
+				bp.debugPrint("EPANDESC: ", found)
+				return false
+			case EventNum:
+				return v.Num == 0x22
 			}
+			return false
+		})
+		if err != nil {
+			return err
 		}
-		if netWrokInfo.isValid() {
-			bp.NetWrokInfo = netWrokInfo
+
+		if found != (NetWrokInfo{}) {
+			bp.NetWrokInfo = found
 			return nil
 		}
 		scanDuration++
@@ -178,443 +199,119 @@ func (bp *BP35A1) SetNetWrokInfo() error {
 }
 
 func (bp *BP35A1) RegistChannel() error {
-	bp.Write([]byte(fmt.Sprintf("SKSREG S2 %s\r\n", bp.NetWrokInfo.Channel)))
-
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(echoBack))
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(ok))
-
-	return nil
+	return bp.sendAndAwaitOK(fmt.Sprintf("SKSREG S2 %s\r\n", bp.NetWrokInfo.Channel))
 }
 
 func (bp *BP35A1) RegistPanID() error {
-	bp.Write([]byte(fmt.Sprintf("SKSREG S3 %s\r\n", bp.NetWrokInfo.PanID)))
-
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(echoBack))
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(ok))
-
-	return nil
+	return bp.sendAndAwaitOK(fmt.Sprintf("SKSREG S3 %s\r\n", bp.NetWrokInfo.PanID))
 }
 
 func (bp *BP35A1) SetIPv6Addr() error {
-	bp.Write([]byte(fmt.Sprintf("SKLL64 %s\r\n", bp.NetWrokInfo.Addr)))
+	sub, end := bp.BeginCmd()
+	defer end()
 
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(echoBack))
+	bp.Write([]byte(fmt.Sprintf("SKLL64 %s\r\n", bp.NetWrokInfo.Addr)))
 
-	line, err := bp.ReadLine()
+	lines := 0
+	var addr string
+	_, err := bp.awaitEvent(sub, 3*time.Second, func(ev any) bool {
+		line, ok := ev.(Line)
+		if !ok {
+			return false
+		}
+		lines++
+		if lines == 2 {
+			addr = strings.TrimRight(line.Text, "\r\n")
+			return true
+		}
+		return false
+	})
 	if err != nil {
 		return err
 	}
-	ipv6Addr := strings.TrimRight(string(line), "\r\n")
-	bp.debugPrint(fmt.Sprintf("IP v6 Addr: %s", ipv6Addr))
 
-	bp.IPv6Addr = ipv6Addr
+	bp.debugPrint(fmt.Sprintf("IP v6 Addr: %s", addr))
+	bp.IPv6Addr = addr
 	return nil
 }
 
 func (bp *BP35A1) ConBRoute() error {
-	bp.Write([]byte(fmt.Sprintf("SKJOIN %s\r\n", bp.IPv6Addr)))
+	// The whole SKJOIN exchange - echo, OK, the EVENT 24/25 outcome and the
+	// debug line that follows it - is held under one BeginCmd, since it's
+	// all one logical command as far as the module is concerned; nothing
+	// else should be writing to the port while a join is in flight anyway.
+	cmd, end := bp.BeginCmd()
+	defer end()
 
-	echoBack, err := bp.ReadLine()
-	if err != nil {
-		return err
-	}
-	bp.debugPrint(string(echoBack))
-
-	ok, err := bp.ReadLine()
-	if err != nil {
+	bp.Write([]byte(fmt.Sprintf("SKJOIN %s\r\n", bp.IPv6Addr)))
+	if err := bp.awaitEchoAndOK(cmd, 3*time.Second); err != nil {
 		return err
 	}
-	bp.debugPrint(string(ok))
-
-	connected := false
-	for !connected {
-		resByte, err := bp.ReadLine()
-		if err != nil {
-			return err
-		}
-		res := string(resByte)
-		if strings.HasPrefix(res, "EVENT 24") {
-			return fmt.Errorf("PANA authentication failed")
-		} else if strings.HasPrefix(string(res), "EVENT 25") {
-			connected = true
-			bp.debugPrint("successful PANA authentication")
-		}
-	}
 
-	instanceList, err := bp.ReadLine()
+	ev, err := bp.awaitEvent(cmd, 30*time.Second, func(ev any) bool {
+		e, ok := ev.(EventNum)
+		return ok && (e.Num == 0x24 || e.Num == 0x25)
+	})
 	if err != nil {
 		return err
 	}
-	bp.debugPrint(string(instanceList))
-
-	return nil
-}
-
-func (bp *BP35A1) GetMeasuredInstantaneous() (int, error) {
-	bp.used.Lock()
-	defer bp.used.Unlock()
-
-	echonetLiteFame := []byte("\x10\x81\x00\x01\x05\xFF\x01\x02\x88\x01\x62\x01\xE7\x00")
-	command := append([]byte(fmt.Sprintf("SKSENDTO 1 %s 0E1A 1 %04X ", bp.IPv6Addr, len(echonetLiteFame))), echonetLiteFame...)
-	bp.debugPrint(hex.EncodeToString(command))
-	bp.Write(command)
-
-	line, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
+	if ev.(EventNum).Num == 0x24 {
+		return fmt.Errorf("PANA authentication failed")
 	}
-	// エコーバック
-	bp.debugPrint(string(line))
+	bp.debugPrint("successful PANA authentication")
 
-	event21, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(event21))
-	if string(event21) == "EVENT 21" {
-		return 0, ErrUnexpectedString
-	}
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(ok))
-
-	erxudp, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(erxudp))
-
-	if !strings.HasPrefix(string(erxudp), "ERXUDP") {
-		return 0, ErrUnexpectedString
-	}
-
-	cols := strings.Split(strings.TrimSpace(string(erxudp)), " ")
-	bp.debugPrint("cols: ", cols)
-	res := cols[8]
-	seoj := res[8 : 8+6]
-	ESV := res[20 : 20+2]
-	EPC := res[24 : 24+2]
-	bp.debugPrint("seoj: ", seoj, "ESV: ", ESV, "EPC: ", EPC)
-
-	if seoj != "028801" || ESV != "72" || EPC != "E7" {
-		return 0, ErrParse
-	}
-
-	r := string(erxudp)
-	mi, err := bp.parseMeasuredInstantaneous(r[len(r)-8:])
-	if err != nil {
-		return 0, ErrParse
-	}
-	bp.debugPrint(fmt.Sprintf("瞬間電力計測値: %d", mi))
-	return mi, nil
-}
-
-func (bp *BP35A1) parseMeasuredInstantaneous(hex string) (int, error) {
-	mi, err := strconv.ParseInt(hex, 16, 64)
-	if err != nil {
-		return 0, err
-	}
-	return int(mi), nil
-}
-
-func (bp *BP35A1) GetCumulativeElectricEnergyUnit() (float64, error) {
-	bp.used.Lock()
-	defer bp.used.Unlock()
-
-	UnitFrame := []byte("\x10\x81\x00\x01\x05\xFF\x01\x02\x88\x01\x62\x01\xE1\x00")
-	command := append([]byte(fmt.Sprintf("SKSENDTO 1 %s 0E1A 1 %04X ", bp.IPv6Addr, len(UnitFrame))), UnitFrame...)
-	bp.Write(command)
-
-	line, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	// エコーバック
-	bp.debugPrint(string(line))
-
-	event21, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(event21))
-	if string(event21) == "EVENT 21" {
-		return 0, ErrUnexpectedString
-	}
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(ok))
-
-	erxudp, err := bp.ReadLine()
-	if err != nil {
-		return 0, err
-	}
-	bp.debugPrint(string(erxudp))
-
-	if !strings.HasPrefix(string(erxudp), "ERXUDP") {
-		return 0, ErrUnexpectedString
-	}
-
-	cols := strings.Split(strings.TrimSpace(string(erxudp)), " ")
-	bp.debugPrint("cols: ", cols)
-	res := cols[8]
-	seoj := res[8 : 8+6]
-	ESV := res[20 : 20+2]
-	EPC := res[24 : 24+2]
-	bp.debugPrint("seoj: ", seoj, "ESV: ", ESV, "EPC: ", EPC)
-
-	if seoj != "028801" || ESV != "72" || EPC != "E1" {
-		return 0, ErrParse
-	}
-
-	r := string(erxudp)
-	unit, err := bp.parseCumulativeElectricEnergyUnit(r[len(r)-2:])
-	if err != nil {
-		return 0, err
-	}
-
-	bp.debugPrint(fmt.Sprintf("積算電力量単位: %fkWh", unit))
-	return unit, nil
-}
-
-func (bp *BP35A1) parseCumulativeElectricEnergyUnit(data string) (float64, error) {
-	u, err := strconv.ParseInt(data, 16, 64)
-	if err != nil {
-		return 0, err
-	}
-	var unit float64
-	switch u {
-	case 0:
-		unit = 1
-	case 1:
-		unit = 0.1
-	case 2:
-		unit = 0.01
-	case 3:
-		unit = 0.001
-	case 4:
-		unit = 0.0001
-	case 10:
-		unit = 10
-	case 11:
-		unit = 100
-	case 12:
-		unit = 1000
-	case 13:
-		unit = 10000
-	default:
-		bp.debugPrint("inccorect number: ", u)
-		return 0, ErrParse
-	}
-	return unit, nil
-}
-
-func (bp *BP35A1) GetRegularTimeNormalDirectionCumulativeElectricEnergy() (int, *time.Time, error) {
-	bp.used.Lock()
-	defer bp.used.Unlock()
-
-	cumulativeElectricEnergyFrame := []byte("\x10\x81\x00\x01\x05\xFF\x01\x02\x88\x01\x62\x01\xEA\x00")
-	command := append([]byte(fmt.Sprintf("SKSENDTO 1 %s 0E1A 1 %04X ", bp.IPv6Addr, len(cumulativeElectricEnergyFrame))), cumulativeElectricEnergyFrame...)
-	bp.Write(command)
-
-	line, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, err
-	}
-	bp.debugPrint(string(line))
-
-	event21, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, err
-	}
-	bp.debugPrint(string(event21))
-	if string(event21) == "EVENT 21" {
-		return 0, nil, ErrUnexpectedString
-	}
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, err
-	}
-	bp.debugPrint(string(ok))
-
-	erxudp, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, err
-	}
-	bp.debugPrint(string(erxudp))
-
-	if !strings.HasPrefix(string(erxudp), "ERXUDP") {
-		return 0, nil, ErrUnexpectedString
-	}
-
-	cols := strings.Split(strings.TrimSpace(string(erxudp)), " ")
-	bp.debugPrint("cols: ", cols)
-	res := cols[8]
-	seoj := res[8 : 8+6]
-	ESV := res[20 : 20+2]
-	EPC := res[24 : 24+2]
-	bp.debugPrint("seoj: ", seoj, "ESV: ", ESV, "EPC: ", EPC)
-
-	if seoj != "028801" || ESV != "72" || EPC != "EA" {
-		return 0, nil, ErrParse
-	}
-
-	r := string(erxudp)
-	cee, time, err := bp.parseRegularTimeNormalDirectionCumulativeElectricEnergy(r[len(r)-22:])
-	if err != nil {
-		return 0, nil, err
-	}
-
-	bp.debugPrint("定時: ", time)
-	bp.debugPrint("積算電力量: ", cee)
-
-	return int(cee), time, nil
-}
-
-func (bp *BP35A1) parseRegularTimeNormalDirectionCumulativeElectricEnergy(data string) (int, *time.Time, error) {
-	tmp := data[:4]
-	yy, _ := strconv.ParseInt(tmp, 16, 64)
-	tmp = data[4 : 4+2]
-	MM, _ := strconv.ParseInt(tmp, 16, 64)
-	tmp = data[6 : 6+2]
-	dd, _ := strconv.ParseInt(tmp, 16, 64)
-
-	tmp = data[8 : 8+2]
-	hh, _ := strconv.ParseInt(tmp, 16, 64)
-	tmp = data[10 : 10+2]
-	mm, _ := strconv.ParseInt(tmp, 16, 64)
-	tmp = data[12 : 12+2]
-	ss, _ := strconv.ParseInt(tmp, 16, 64)
-	time, err := time.Parse("20060102150405", fmt.Sprintf("%04d%02d%02d%02d%02d%02d", yy, MM, dd, hh, mm, ss))
-	if err != nil {
-		return 0, nil, err
-	}
-
-	tmp = data[14:]
-	cumulativeElectricEnergy, err := strconv.ParseInt(tmp, 16, 64)
-	if err != nil {
-		return 0, nil, ErrParse
-	}
-
-	return int(cumulativeElectricEnergy), &time, nil
+	_, err = bp.awaitEvent(cmd, 3*time.Second, func(ev any) bool {
+		line, ok := ev.(Line)
+		if ok {
+			bp.debugPrint(line.Text)
+		}
+		return ok
+	})
+	return err
 }
 
-func (bp *BP35A1) GetUnitAndRegularTimeNormalDirectionCumulativeElectricEnergy() (int, *time.Time, float64, error) {
-	bp.used.Lock()
-	defer bp.used.Unlock()
-
-	cumulativeElectricEnergyAndUnitFrame := []byte("\x10\x81\x00\x01\x05\xFF\x01\x02\x88\x01\x62\x02\xE1\x00\xEA\x00")
-	command := append([]byte(fmt.Sprintf("SKSENDTO 1 %s 0E1A 1 %04X ", bp.IPv6Addr, len(cumulativeElectricEnergyAndUnitFrame))), cumulativeElectricEnergyAndUnitFrame...)
-	bp.Write(command)
-
-	line, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, 0, err
-	}
-	bp.debugPrint(string(line))
+// Rejoin re-authenticates with the coordinator via SKREJOIN, which the
+// module accepts in place of a full SKSCAN/SKJOIN when the PANA session
+// is merely expiring rather than lost outright.
+func (bp *BP35A1) Rejoin() error {
+	cmd, end := bp.BeginCmd()
+	defer end()
 
-	event21, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, 0, err
-	}
-	bp.debugPrint(string(event21))
-	if string(event21) == "EVENT 21" {
-		return 0, nil, 0, ErrUnexpectedString
-	}
-
-	ok, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, 0, err
-	}
-	bp.debugPrint(string(ok))
-
-	erxudp, err := bp.ReadLine()
-	if err != nil {
-		return 0, nil, 0, err
-	}
-	bp.debugPrint(string(erxudp))
-
-	if !strings.HasPrefix(string(erxudp), "ERXUDP") {
-		return 0, nil, 0, ErrUnexpectedString
-	}
-
-	cols := strings.Split(strings.TrimSpace(string(erxudp)), " ")
-	bp.debugPrint("cols: ", cols)
-	res := cols[8]
-	seoj := res[8 : 8+6]
-	ESV := res[20 : 20+2]
-	EPC1 := res[24 : 24+2]
-	EPC2 := res[30 : 30+2]
-	bp.debugPrint("seoj: ", seoj, "ESV: ", ESV, "EPC1: ", EPC1, "EPC2: ", EPC2)
-
-	if seoj != "028801" || ESV != "72" || EPC1 != "E1" || EPC2 != "EA" {
-		return 0, nil, 0, ErrParse
+	bp.Write([]byte("SKREJOIN\r\n"))
+	if err := bp.awaitEchoAndOK(cmd, 3*time.Second); err != nil {
+		return err
 	}
 
-	unit, err := bp.parseCumulativeElectricEnergyUnit(res[28 : 28+2])
+	ev, err := bp.awaitEvent(cmd, 30*time.Second, func(ev any) bool {
+		e, ok := ev.(EventNum)
+		return ok && (e.Num == 0x24 || e.Num == 0x25)
+	})
 	if err != nil {
-		return 0, nil, 0, err
+		return err
 	}
-	bp.debugPrint("unit: ", unit)
-	bp.debugPrint("res: ", res[34:34+22])
-
-	cee, time, err := bp.parseRegularTimeNormalDirectionCumulativeElectricEnergy(res[34 : 34+22])
-	if err != nil {
-		return 0, nil, 0, err
+	if ev.(EventNum).Num == 0x24 {
+		return fmt.Errorf("PANA re-authentication failed")
 	}
-	bp.debugPrint("定時: ", time)
-	bp.debugPrint("積算電力量: ", cee)
-
-	return cee, time, unit, nil
+	bp.debugPrint("successful PANA re-authentication")
+	return nil
 }
 
 func NewBP35A1(portName string, baudRate int, RBID string, RBPW string, debugMode bool) (*BP35A1, error) {
-	port, err := serial.Open(portName, &serial.Mode{
-		BaudRate: baudRate,
-		DataBits: 8,
-	})
+	transport, err := NewSerialTransport(portName, baudRate)
 	if err != nil {
 		return nil, err
 	}
 
-	r := bufio.NewReader(port)
-
 	BP35A1 := &BP35A1{
-		Port:        port,
-		BufReader:   r,
+		Transport:   transport,
 		Debug:       debugMode,
 		DebugWriter: os.Stdout,
 		RouteB_ID:   RBID,
 		RouteB_PW:   RBPW,
 	}
+	BP35A1.events = newEventLoop(BP35A1)
+	go BP35A1.events.run()
+
 	return BP35A1, nil
 }
 
@@ -623,6 +320,12 @@ func main() {
 	RBID := flag.String("i", "", "Bルート認証ID")
 	RBPW := flag.String("P", "", "Bルート認証パスワード")
 	debugMode := flag.Bool("d", false, "デバッグモード")
+	sinkKind := flag.String("sink", "stdout", "計測値の出力先 (stdout, prometheus, sql)")
+	metricsAddr := flag.String("metrics-addr", ":9100", "-sink=prometheus 時の待受アドレス")
+	sqlDriver := flag.String("sql-driver", "sqlite3", "-sink=sql 時の database/sql ドライバ名")
+	sqlDSN := flag.String("sql-dsn", "smartmeter.db", "-sink=sql 時の接続文字列")
+	scanBackoff := flag.Duration("scan-backoff", 5*time.Second, "再接続時の初回バックオフ")
+	maxScanBackoff := flag.Duration("max-scan-backoff", 5*time.Minute, "再接続時の最大バックオフ")
 	flag.Parse()
 
 	if *portNmae == "" {
@@ -640,50 +343,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	BP35A1, err := NewBP35A1(*portNmae, 115200, *RBID, *RBPW, *debugMode)
+	measurementSink, err := newSink(*sinkKind, *metricsAddr, *sqlDriver, *sqlDSN)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ポートに接続できませんでした\n%s\n", err)
+		fmt.Fprintf(os.Stderr, "出力先の初期化に失敗しました\n%s\n", err)
 		os.Exit(1)
 	}
-	defer BP35A1.Close()
-
-	BP35A1.ResetOutputBuffer()
-	BP35A1.ResetInputBuffer()
-
-	err = BP35A1.RouteBLogin()
-	if err != nil {
-		fmt.Println(err)
-	}
 
-	err = BP35A1.SetNetWrokInfo()
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	err = BP35A1.RegistChannel()
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println("finish regist channel")
+	cfg := DefaultSessionConfig()
+	cfg.InitialScanBackoff = *scanBackoff
+	cfg.MaxScanBackoff = *maxScanBackoff
+	session := NewSession(*portNmae, 115200, *RBID, *RBPW, *debugMode, cfg)
 
-	err = BP35A1.RegistPanID()
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println("finish regist pan id")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	err = BP35A1.SetIPv6Addr()
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	err = BP35A1.ConBRoute()
-	if err != nil {
-		fmt.Println(err)
-	}
-	fmt.Println("successful connection to B route")
+	stateCh, unsubscribeState := session.Subscribe()
+	defer unsubscribeState()
+	go func() {
+		for state := range stateCh {
+			fmt.Println("session:", state)
+			if aware, ok := measurementSink.(sink.SessionAware); ok {
+				aware.SetSessionUp(state == StateAuthenticated)
+			}
+		}
+	}()
 
-	BP35A1.SetReadTimeout(10 * time.Second)
+	go func() {
+		if err := session.Run(ctx); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "セッションが終了しました\n%s\n", err)
+			os.Exit(1)
+		}
+	}()
 
 	measuredInstantaneousTicker := time.NewTicker(1 * time.Second)
 	EnergyTicker := time.NewTicker(10 * time.Second)
@@ -692,23 +382,54 @@ func main() {
 		select {
 		case <-measuredInstantaneousTicker.C:
 			go func() {
-				measuredInstantaneous, err := BP35A1.GetMeasuredInstantaneous()
+				bp := session.BP35A1()
+				if bp == nil || session.State() != StateAuthenticated {
+					return
+				}
+
+				measuredInstantaneous, err := bp.SmartMeter().InstantaneousPower()
 				if err != nil {
 					fmt.Println(err)
-					os.Exit(1)
+					return
 				}
 				fmt.Println("瞬間電力量", measuredInstantaneous, "w")
 
+				err = measurementSink.Record(ctx, sink.Measurement{
+					MeterAddr: bp.IPv6Addr,
+					EPC:       0xE7,
+					Timestamp: time.Now(),
+					Value:     float64(measuredInstantaneous),
+					Unit:      "W",
+				})
+				if err != nil {
+					fmt.Println("sink:", err)
+				}
 			}()
 		case <-EnergyTicker.C:
 			go func() {
-				cee, t, unit, err := BP35A1.GetUnitAndRegularTimeNormalDirectionCumulativeElectricEnergy()
+				bp := session.BP35A1()
+				if bp == nil || session.State() != StateAuthenticated {
+					return
+				}
+
+				res, err := bp.SmartMeter().UnitAndRegularTimeNormalDirectionCumulativeElectricEnergy()
 				if err != nil {
 					fmt.Println(err)
-					os.Exit(1)
+					return
+				}
+				fmt.Println("計測時間: ", res.CumulativeEnergyNormalAt)
+				fmt.Println("積算電力量: ", float64(res.CumulativeEnergyNormal)*res.CumulativeEnergyUnit, "kwh")
+
+				err = measurementSink.Record(ctx, sink.Measurement{
+					MeterAddr: bp.IPv6Addr,
+					EPC:       0xEA,
+					Timestamp: res.CumulativeEnergyNormalAt,
+					Value:     float64(res.CumulativeEnergyNormal) * res.CumulativeEnergyUnit,
+					Unit:      "kWh",
+				})
+				if err != nil {
+					fmt.Println("sink:", err)
 				}
-				fmt.Println("計測時間: ", t)
-				fmt.Println("積算電力量: ", float64(cee)*float64(unit), "kwh")
 			}()
 		}
 	}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventLoop owns the single goroutine reading lines off the Transport. It
+// classifies each one into a typed event (see skstack.go) and fans it out
+// to every current subscriber, so request methods no longer race each
+// other for the next N lines and unsolicited events (session drops,
+// spontaneous meter pushes) don't break an in-flight request.
+//
+// Line, OK and Fail are the exception: the module's echo-back/OK/FAIL
+// handshake carries no id of its own, so there is no way to tell which
+// in-flight command a given one belongs to once it's broadcast. Instead of
+// broadcasting them, the loop delivers them exclusively to whichever
+// command currently holds BeginCmd - the module itself only ever has one
+// command outstanding at a time, so this mirrors its own ordering instead
+// of trying to invent a correlation id the protocol doesn't carry. EVENT
+// NN lines follow the same routing while a command holds BeginCmd; see
+// run.
+type eventLoop struct {
+	bp *BP35A1
+
+	mu   sync.Mutex
+	subs map[uint64]chan any
+	next uint64
+
+	cmdMu  sync.Mutex
+	active chan any
+}
+
+func newEventLoop(bp *BP35A1) *eventLoop {
+	return &eventLoop{bp: bp, subs: make(map[uint64]chan any)}
+}
+
+// Subscribe returns a channel of every event broadcast from now on, and an
+// unsubscribe func the caller must eventually call to release it.
+func (l *eventLoop) Subscribe() (<-chan any, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.next
+	l.next++
+	ch := make(chan any, 32)
+	l.subs[id] = ch
+
+	return ch, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if ch, ok := l.subs[id]; ok {
+			delete(l.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// BeginCmd reserves exclusive right to receive the module's next Line, OK
+// and Fail events, until the returned end func is called. Only one BeginCmd
+// can be outstanding at a time: a second caller blocks until the first
+// calls end, the same way the module itself only ever processes one
+// command at a time. Callers that also need to watch for asynchronous,
+// self-identifying events (EVENT NN, ERXUDP by TID) should hold a separate
+// Subscribe alongside this for as long as they need it, and call end as
+// soon as the echo/OK/FAIL handshake is done so unrelated commands aren't
+// blocked behind a slow asynchronous wait.
+func (l *eventLoop) BeginCmd() (<-chan any, func()) {
+	l.cmdMu.Lock()
+
+	ch := make(chan any, 8)
+	l.mu.Lock()
+	l.active = ch
+	l.mu.Unlock()
+
+	return ch, func() {
+		l.mu.Lock()
+		l.active = nil
+		l.mu.Unlock()
+		l.cmdMu.Unlock()
+	}
+}
+
+// deliverToActive hands ev to the current BeginCmd channel, if any, and
+// reports whether one was active to receive it.
+func (l *eventLoop) deliverToActive(ev any) bool {
+	l.mu.Lock()
+	ch := l.active
+	l.mu.Unlock()
+
+	if ch == nil {
+		return false
+	}
+	select {
+	case ch <- ev:
+	default:
+		// BeginCmd's buffer is sized for the longest echo/OK/FAIL exchange;
+		// this should never happen.
+	}
+	return true
+}
+
+func (l *eventLoop) broadcast(ev any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ch := range l.subs {
+		select {
+		case ch <- ev:
+		default:
+			// A slow subscriber drops events rather than stalling the
+			// reader; request methods only care about the next matching
+			// one, not an exhaustive log.
+		}
+	}
+}
+
+// run reads lines until the Transport errors out (EOF, timeout, ...) and
+// broadcasts that error to every subscriber before returning.
+func (l *eventLoop) run() {
+	var building EPANDESC
+
+	for {
+		raw, err := l.bp.Transport.ReadLine()
+		if err != nil {
+			l.broadcast(err)
+			l.deliverToActive(err)
+			return
+		}
+		line := string(raw)
+
+		if strings.HasPrefix(line, "  ") {
+			l.accumulateEPANDESC(&building, line)
+			continue
+		}
+
+		if building.isValid() {
+			l.broadcast(building)
+			building = EPANDESC{}
+		}
+
+		if line == "EPANDESC" {
+			continue
+		}
+
+		ev, err := parseLine(raw)
+		if err != nil {
+			continue
+		}
+
+		switch ev.(type) {
+		case Line, OK, Fail, EventNum:
+			// EVENT NN lines are almost always the direct outcome of
+			// whatever command is currently active (21: UDP send failed,
+			// 22: scan complete, 24/25: PANA auth result) rather than a
+			// standalone broadcast notification, so they follow the same
+			// routing as the echo/OK/FAIL handshake. A genuinely
+			// asynchronous one (29: session expiring, 32/33: ARIB restart)
+			// arrives while nothing is active and falls through to
+			// broadcast as usual.
+			if !l.deliverToActive(ev) {
+				l.broadcast(ev)
+			}
+		default:
+			l.broadcast(ev)
+		}
+	}
+}
+
+func (l *eventLoop) accumulateEPANDESC(building *EPANDESC, line string) {
+	cols := strings.SplitN(strings.TrimSpace(line), ":", 2)
+	if len(cols) != 2 {
+		return
+	}
+
+	if cols[0] == "Channel" && building.isValid() {
+		l.broadcast(*building)
+		*building = EPANDESC{}
+	}
+
+	switch cols[0] {
+	case "Channel":
+		building.Channel = cols[1]
+	case "Channel Page":
+		building.ChannelPage = cols[1]
+	case "Pan ID":
+		building.PanID = cols[1]
+	case "Addr":
+		building.Addr = cols[1]
+	case "LQI":
+		building.LQI = cols[1]
+	case "PairID":
+		building.PairID = cols[1]
+	}
+}
+
+// awaitEvent reads from sub until match returns true for an event, the
+// timeout elapses, the reader passes on the transport error it stopped
+// on, sub is closed by its unsubscribe func, or the module reports
+// "FAIL ERxx". A FAIL always ends the wait immediately with the
+// code it carried, rather than leaving every matcher to notice it (or,
+// worse, not notice it and time out instead).
+func (bp *BP35A1) awaitEvent(sub <-chan any, timeout time.Duration, match func(any) bool) (any, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case ev, ok := <-sub:
+			if !ok {
+				return nil, ErrReadTimeout
+			}
+			if err, ok := ev.(error); ok {
+				return nil, err
+			}
+			if fail, ok := ev.(Fail); ok {
+				return nil, fmt.Errorf("command failed: %s", fail.Code)
+			}
+			if match(ev) {
+				return ev, nil
+			}
+		case <-deadline.C:
+			return nil, ErrReadTimeout
+		}
+	}
+}
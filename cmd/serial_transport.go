@@ -0,0 +1,70 @@
+package main
+
+import (
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// SerialTransport is the production Transport, backed by a real serial port
+// connected to a BP35A1 module.
+type SerialTransport struct {
+	port serial.Port
+}
+
+func NewSerialTransport(portName string, baudRate int) (*SerialTransport, error) {
+	port, err := serial.Open(portName, &serial.Mode{
+		BaudRate: baudRate,
+		DataBits: 8,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SerialTransport{port: port}, nil
+}
+
+func (t *SerialTransport) Write(p []byte) (int, error) {
+	return t.port.Write(p)
+}
+
+func (t *SerialTransport) ReadLine() ([]byte, error) {
+	var data []byte
+	buf := make([]byte, 1)
+
+	for {
+		_, err := t.port.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, buf...)
+
+		if buf[0] == 0 {
+			return []byte(""), ErrReadTimeout
+		}
+
+		if len(data) >= 2 && data[len(data)-2] == '\r' && data[len(data)-1] == '\n' {
+			// "\r\n"が到着したら読み込み終了
+			break
+		}
+	}
+
+	return data[:len(data)-2], nil
+}
+
+func (t *SerialTransport) ResetInputBuffer() error {
+	return t.port.ResetInputBuffer()
+}
+
+func (t *SerialTransport) ResetOutputBuffer() error {
+	return t.port.ResetOutputBuffer()
+}
+
+func (t *SerialTransport) SetReadTimeout(d time.Duration) error {
+	return t.port.SetReadTimeout(d)
+}
+
+func (t *SerialTransport) Close() error {
+	return t.port.Close()
+}
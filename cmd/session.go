@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SessionState is where a Session sits in the connect/authenticate
+// lifecycle.
+type SessionState int
+
+const (
+	StateDisconnected SessionState = iota
+	StateScanning
+	StateJoining
+	StateAuthenticated
+	StateRekeying
+	StateFailed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateScanning:
+		return "scanning"
+	case StateJoining:
+		return "joining"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateRekeying:
+		return "rekeying"
+	case StateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// SessionConfig bounds how aggressively Session.Run retries a failed
+// scan/join, so it doesn't hammer the concentrator.
+type SessionConfig struct {
+	InitialScanBackoff time.Duration
+	MaxScanBackoff     time.Duration
+	// JitterFraction adds up to this fraction of the current backoff as
+	// random jitter, e.g. 0.2 for up to +20%.
+	JitterFraction float64
+}
+
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		InitialScanBackoff: 5 * time.Second,
+		MaxScanBackoff:     5 * time.Minute,
+		JitterFraction:     0.2,
+	}
+}
+
+// Session owns a BP35A1's connect/authenticate lifecycle: initial
+// SKSCAN/SKJOIN, SKREJOIN on session lifetime expiry, and a full
+// reconnect (reopening the serial port and replaying the join sequence)
+// if the connection is lost outright. connect can reuse a known
+// NetWrokInfo to skip SKSCAN, but Run clears its last known NetWrokInfo
+// after every failed attempt and every lost connection, so each reconnect
+// rescans.
+type Session struct {
+	portName  string
+	baudRate  int
+	routeBID  string
+	routeBPW  string
+	debugMode bool
+	cfg       SessionConfig
+
+	// newBP35A1 opens the module connect() dials. It's NewBP35A1 in
+	// production; tests override it to hand back a FakeTransport-backed
+	// BP35A1 instead of touching a real serial port.
+	newBP35A1 func(portName string, baudRate int, routeBID, routeBPW string, debugMode bool) (*BP35A1, error)
+
+	mu    sync.Mutex
+	bp    *BP35A1
+	state SessionState
+	subs  map[uint64]chan SessionState
+	next  uint64
+}
+
+func NewSession(portName string, baudRate int, routeBID, routeBPW string, debugMode bool, cfg SessionConfig) *Session {
+	return &Session{
+		portName:  portName,
+		baudRate:  baudRate,
+		routeBID:  routeBID,
+		routeBPW:  routeBPW,
+		debugMode: debugMode,
+		cfg:       cfg,
+		newBP35A1: NewBP35A1,
+		subs:      make(map[uint64]chan SessionState),
+	}
+}
+
+// BP35A1 returns the module currently in use, or nil before the first
+// successful connection.
+func (s *Session) BP35A1() *BP35A1 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bp
+}
+
+// State returns the current lifecycle state.
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Subscribe returns every state transition from now on, and an
+// unsubscribe func the caller must call once done. The sink layer uses
+// this to label metrics as stale while the session isn't Authenticated.
+func (s *Session) Subscribe() (<-chan SessionState, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.next
+	s.next++
+	ch := make(chan SessionState, 8)
+	s.subs[id] = ch
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if ch, ok := s.subs[id]; ok {
+			delete(s.subs, id)
+			close(ch)
+		}
+	}
+}
+
+func (s *Session) setState(state SessionState) {
+	s.mu.Lock()
+	s.state = state
+	subs := make([]chan SessionState, 0, len(s.subs))
+	for _, ch := range s.subs {
+		subs = append(subs, ch)
+	}
+	s.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// Run drives the connect/reconnect loop until ctx is done, never
+// returning otherwise. A failed connection attempt or a lost connection
+// both lead back to the top of the loop with exponential backoff; a
+// clean Authenticated session resets the backoff.
+func (s *Session) Run(ctx context.Context) error {
+	backoff := s.cfg.InitialScanBackoff
+	var lastKnown NetWrokInfo
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		bp, err := s.connect(ctx, lastKnown)
+		if err != nil {
+			if err == context.Canceled || err == context.DeadlineExceeded {
+				return err
+			}
+			// connect may have failed precisely because lastKnown is stale
+			// (the PAN changed channel/PAN ID); rescan from scratch next
+			// time instead of retrying the same bad scan info forever.
+			lastKnown = NetWrokInfo{}
+			s.setState(StateFailed)
+			if !s.backoffSleep(ctx, &backoff) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		lastKnown = bp.NetWrokInfo
+		backoff = s.cfg.InitialScanBackoff
+		s.setState(StateAuthenticated)
+
+		err = s.superviseConnection(ctx, bp)
+		bp.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// superviseConnection only returns nil once ctx is done, so
+			// every lost connection (a failed rejoin, a module restart, a
+			// read failure) lands here and the next attempt rescans.
+			lastKnown = NetWrokInfo{}
+		}
+		s.setState(StateDisconnected)
+	}
+}
+
+// connect opens the serial port and runs the full scan/join sequence,
+// reusing known if it still describes a reachable PAN.
+func (s *Session) connect(ctx context.Context, known NetWrokInfo) (*BP35A1, error) {
+	bp, err := s.newBP35A1(s.portName, s.baudRate, s.routeBID, s.routeBPW, s.debugMode)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.ResetOutputBuffer()
+	bp.ResetInputBuffer()
+
+	if err := bp.RouteBLogin(); err != nil {
+		bp.Close()
+		return nil, err
+	}
+
+	if known != (NetWrokInfo{}) {
+		bp.NetWrokInfo = known
+	} else {
+		s.setState(StateScanning)
+		if err := bp.SetNetWrokInfo(); err != nil {
+			bp.Close()
+			return nil, err
+		}
+	}
+
+	if err := bp.RegistChannel(); err != nil {
+		bp.Close()
+		return nil, err
+	}
+	if err := bp.RegistPanID(); err != nil {
+		bp.Close()
+		return nil, err
+	}
+	if err := bp.SetIPv6Addr(); err != nil {
+		bp.Close()
+		return nil, err
+	}
+
+	s.setState(StateJoining)
+	if err := bp.ConBRoute(); err != nil {
+		bp.Close()
+		return nil, err
+	}
+
+	bp.SetReadTimeout(10 * time.Second)
+
+	s.mu.Lock()
+	s.bp = bp
+	s.mu.Unlock()
+
+	return bp, nil
+}
+
+// superviseConnection watches bp's events while Authenticated. It
+// transparently rekeys on session lifetime expiry (EVENT 29) and returns
+// an error - sending control back to Run to reconnect from scratch - on
+// an ARIB restart (EVENT 32/33) or on a read failure, after which bp's
+// event loop has stopped.
+func (s *Session) superviseConnection(ctx context.Context, bp *BP35A1) error {
+	sub, unsubscribe := bp.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-sub:
+			if !ok {
+				return fmt.Errorf("session: event stream closed")
+			}
+
+			// bp's event loop broadcasts the first read error it sees and
+			// then stops, so no further events follow one.
+			if err, isErr := ev.(error); isErr {
+				return err
+			}
+
+			event, ok := ev.(EventNum)
+			if !ok {
+				continue
+			}
+
+			switch event.Num {
+			case 0x29: // session lifetime expiring
+				s.setState(StateRekeying)
+				if err := bp.Rejoin(); err != nil {
+					return err
+				}
+				s.setState(StateAuthenticated)
+			case 0x32, 0x33: // ARIB restart
+				return fmt.Errorf("session: module reported ARIB restart (event %02X)", event.Num)
+			}
+		}
+	}
+}
+
+// backoffSleep waits out the current backoff (plus jitter), doubling it
+// up to MaxScanBackoff for next time. It returns false if ctx was
+// cancelled during the wait.
+func (s *Session) backoffSleep(ctx context.Context, backoff *time.Duration) bool {
+	jitter := time.Duration(rand.Float64() * s.cfg.JitterFraction * float64(*backoff))
+
+	select {
+	case <-time.After(*backoff + jitter):
+	case <-ctx.Done():
+		return false
+	}
+
+	*backoff *= 2
+	if *backoff > s.cfg.MaxScanBackoff {
+		*backoff = s.cfg.MaxScanBackoff
+	}
+	return true
+}
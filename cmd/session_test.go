@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+const (
+	testSessionRBID = "00112233445566778899AABBCCDDEEFF"
+	testSessionRBPW = "password"
+	testScanAddr    = "00112233445566778899AABBCCDDEEFF"
+	testIPv6Addr    = "FE80:0000:0000:0000:0011:2233:4455:6677"
+)
+
+// newTestSession wires a Session to hand out FakeTransport-backed BP35A1s
+// instead of opening a real serial port, one per call to newBP35A1 in
+// transports order - the same way a real reconnect opens a fresh handle to
+// the port rather than reusing the old (now-Closed) one.
+func newTestSession(cfg SessionConfig, transports ...*FakeTransport) *Session {
+	s := NewSession("fake", 115200, testSessionRBID, testSessionRBPW, false, cfg)
+	next := 0
+	s.newBP35A1 = func(portName string, baudRate int, routeBID, routeBPW string, debugMode bool) (*BP35A1, error) {
+		transport := transports[next]
+		next++
+		bp := &BP35A1{
+			Transport:   transport,
+			DebugWriter: io.Discard,
+			RouteB_ID:   routeBID,
+			RouteB_PW:   routeBPW,
+		}
+		bp.events = newEventLoop(bp)
+		go bp.events.run()
+		return bp, nil
+	}
+	return s
+}
+
+// queueFullConnect stages every batch one successful Session.connect()
+// attempt writes. scan controls whether a SKSCAN exchange is included -
+// false when the caller expects connect to reuse lastKnown instead.
+func queueFullConnect(transport *FakeTransport, scan bool) {
+	transport.QueueLines("SKSETRBID echo", "OK")
+	transport.QueueLines("SKSETPWD echo", "OK")
+	if scan {
+		transport.QueueLines(
+			"SKSCAN echo",
+			"OK",
+			"EPANDESC",
+			"  Channel:3B",
+			"  Channel Page:09",
+			"  Pan ID:8888",
+			"  Addr:"+testScanAddr,
+			"  LQI:E1",
+			"  PairID:12345678",
+			"EVENT 22",
+		)
+	}
+	transport.QueueLines("SKSREG S2 echo", "OK")
+	transport.QueueLines("SKSREG S3 echo", "OK")
+	transport.QueueLines("SKLL64 echo", testIPv6Addr)
+	transport.QueueLines("SKJOIN echo", "OK", "EVENT 25", "PANA initialized")
+}
+
+func waitForState(t *testing.T, states <-chan SessionState, want SessionState) {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case st := <-states:
+			if st == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for state %v", want)
+		}
+	}
+}
+
+func TestSessionScanFailureBacksOffAndRetries(t *testing.T) {
+	t1 := NewFakeTransport()
+	t1.QueueLines("SKSETRBID echo", "OK")
+	t1.QueueLines("SKSETPWD echo", "OK")
+	t1.QueueLines("SKSCAN echo", "FAIL ER10")
+
+	t2 := NewFakeTransport()
+	t2.QueueLines("SKSETRBID echo", "OK")
+	t2.QueueLines("SKSETPWD echo", "OK")
+	t2.QueueLines("SKSCAN echo", "FAIL ER10")
+
+	cfg := SessionConfig{InitialScanBackoff: 5 * time.Millisecond, MaxScanBackoff: 20 * time.Millisecond}
+	s := newTestSession(cfg, t1, t2)
+
+	states, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	failures := 0
+	timeout := time.After(3 * time.Second)
+	for failures < 2 {
+		select {
+		case st := <-states:
+			if st == StateFailed {
+				failures++
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for two scan failures")
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestSessionRejoinsOnSessionExpiry(t *testing.T) {
+	transport := NewFakeTransport()
+	queueFullConnect(transport, true)
+	transport.QueueLines("SKREJOIN echo", "OK", "EVENT 25")
+
+	cfg := SessionConfig{InitialScanBackoff: time.Second, MaxScanBackoff: time.Second}
+	s := newTestSession(cfg, transport)
+
+	states, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	waitForState(t, states, StateAuthenticated)
+
+	transport.QueueAsync("EVENT 29")
+
+	waitForState(t, states, StateRekeying)
+	waitForState(t, states, StateAuthenticated)
+}
+
+func TestSessionFullReconnectOnARIBRestartClearsLastKnown(t *testing.T) {
+	t1 := NewFakeTransport()
+	queueFullConnect(t1, true)
+
+	t2 := NewFakeTransport()
+	queueFullConnect(t2, true) // scans again - proves lastKnown was cleared
+
+	cfg := SessionConfig{InitialScanBackoff: time.Millisecond, MaxScanBackoff: time.Millisecond}
+	s := newTestSession(cfg, t1, t2)
+
+	states, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	waitForState(t, states, StateAuthenticated)
+
+	t1.QueueAsync("EVENT 32")
+
+	waitForState(t, states, StateDisconnected)
+	waitForState(t, states, StateScanning)
+	waitForState(t, states, StateAuthenticated)
+}
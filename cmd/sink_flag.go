@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ta2min/smart-meter-client/pkg/sink"
+)
+
+// newSink builds the Sink selected by -sink. For "prometheus" it also
+// starts the /metrics and /healthz HTTP server in the background.
+func newSink(kind, metricsAddr, sqlDriver, sqlDSN string) (sink.Sink, error) {
+	switch kind {
+	case "stdout":
+		return sink.NewStdoutSink(os.Stdout), nil
+
+	case "prometheus":
+		s := sink.NewPrometheusSink(metricsAddr)
+		go func() {
+			if err := s.ListenAndServe(); err != nil {
+				fmt.Fprintln(os.Stderr, "prometheus sink:", err)
+			}
+		}()
+		return s, nil
+
+	case "sql":
+		db, err := sql.Open(sqlDriver, sqlDSN)
+		if err != nil {
+			return nil, fmt.Errorf("open %s database: %w", sqlDriver, err)
+		}
+		return sink.NewSQLSink(context.Background(), db, sqlDriver)
+
+	default:
+		return nil, fmt.Errorf("unknown sink %q (want stdout, prometheus or sql)", kind)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// OK is emitted for a bare "OK" line acknowledging the previous command.
+type OK struct{}
+
+// Fail is emitted for a "FAIL ERxx" line.
+type Fail struct{ Code string }
+
+// EventNum is emitted for an "EVENT NN [SENDER]" line, e.g. EVENT 21 (UDP
+// send failed), EVENT 22 (scan complete), EVENT 24/25 (PANA auth result),
+// EVENT 29 (session lifetime expiring), EVENT 32/33 (ARIB restarts).
+type EventNum struct {
+	Num    int
+	Sender string
+}
+
+// EPANDESC is one PAN candidate reported by SKSCAN, assembled by the event
+// loop from its indented "  Key:Value" lines.
+type EPANDESC struct {
+	Channel     string
+	ChannelPage string
+	PanID       string
+	Addr        string
+	LQI         string
+	PairID      string
+}
+
+func (e EPANDESC) isValid() bool {
+	return e.Channel != "" && e.ChannelPage != "" && e.PanID != "" && e.Addr != "" && e.LQI != "" && e.PairID != ""
+}
+
+// ERXUDP is a UDP datagram delivered by the module, solicited or not. Data
+// holds the raw ECHONET Lite frame bytes; use echonet.Unmarshal to decode
+// it.
+type ERXUDP struct {
+	Sender    string
+	Dest      string
+	RPort     string
+	LPort     string
+	SenderLLA string
+	Secured   string
+	Data      []byte
+}
+
+// Line is any line the event loop didn't classify into one of the above:
+// command echo-backs, version strings, IPv6 addresses, instance lists, and
+// so on. Callers that need one of these just take the Nth Line they see.
+type Line struct{ Text string }
+
+// parseLine classifies a single line of SKSTACK-IP output. It never sees
+// the indented EPANDESC body lines, which the event loop consumes itself
+// to assemble an EPANDESC before calling parseLine on whatever follows.
+func parseLine(raw []byte) (any, error) {
+	line := string(raw)
+
+	switch {
+	case line == "OK":
+		return OK{}, nil
+	case strings.HasPrefix(line, "FAIL "):
+		return Fail{Code: strings.TrimPrefix(line, "FAIL ")}, nil
+	case strings.HasPrefix(line, "EVENT "):
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, ErrParse
+		}
+		num, err := strconv.ParseInt(fields[1], 16, 64)
+		if err != nil {
+			return nil, ErrParse
+		}
+		ev := EventNum{Num: int(num)}
+		if len(fields) >= 3 {
+			ev.Sender = fields[2]
+		}
+		return ev, nil
+	case strings.HasPrefix(line, "ERXUDP "):
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			return nil, ErrParse
+		}
+		data, err := hex.DecodeString(fields[8])
+		if err != nil {
+			return nil, ErrParse
+		}
+		return ERXUDP{
+			Sender:    fields[1],
+			Dest:      fields[2],
+			RPort:     fields[3],
+			LPort:     fields[4],
+			SenderLLA: fields[5],
+			Secured:   fields[6],
+			Data:      data,
+		}, nil
+	default:
+		return Line{Text: line}, nil
+	}
+}
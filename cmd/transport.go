@@ -0,0 +1,17 @@
+package main
+
+import "time"
+
+// Transport abstracts the byte-oriented line protocol that BP35A1 speaks
+// to a Wi-SUN module. Pulling it out from behind a concrete serial.Port
+// lets the SKSTACK-IP command layer run against a scripted FakeTransport in
+// tests, and makes it straightforward to add a driver for a different
+// module (BP35C0, RL7023, ...) later.
+type Transport interface {
+	Write(p []byte) (int, error)
+	ReadLine() ([]byte, error)
+	ResetInputBuffer() error
+	ResetOutputBuffer() error
+	SetReadTimeout(d time.Duration) error
+	Close() error
+}
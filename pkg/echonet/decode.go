@@ -0,0 +1,94 @@
+package echonet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+func decodeInt32(edt []byte) (int, error) {
+	if len(edt) != 4 {
+		return 0, fmt.Errorf("%w: want 4 bytes, got %d", ErrShortEDT, len(edt))
+	}
+	return int(int32(binary.BigEndian.Uint32(edt))), nil
+}
+
+func decodeUint32(edt []byte) (int, error) {
+	if len(edt) != 4 {
+		return 0, fmt.Errorf("%w: want 4 bytes, got %d", ErrShortEDT, len(edt))
+	}
+	return int(binary.BigEndian.Uint32(edt)), nil
+}
+
+func decodeUint8(edt []byte) (int, error) {
+	if len(edt) != 1 {
+		return 0, fmt.Errorf("%w: want 1 byte, got %d", ErrShortEDT, len(edt))
+	}
+	return int(edt[0]), nil
+}
+
+// cumulativeEnergyUnits maps EPC 0xE1's raw code to a kWh-per-count
+// multiplier, per the low-voltage smart meter spec.
+var cumulativeEnergyUnits = map[int]float64{
+	0: 1, 1: 0.1, 2: 0.01, 3: 0.001, 4: 0.0001,
+	10: 10, 11: 100, 12: 1000, 13: 10000,
+}
+
+func decodeCumulativeEnergyUnit(edt []byte) (float64, error) {
+	raw, err := decodeUint8(edt)
+	if err != nil {
+		return 0, err
+	}
+	unit, ok := cumulativeEnergyUnits[raw]
+	if !ok {
+		return 0, fmt.Errorf("%w: cumulative energy unit %d", ErrUnknownCode, raw)
+	}
+	return unit, nil
+}
+
+// decodeCurrent splits a two-phase instantaneous current EDT (R-phase,
+// T-phase) into amperes, each carried as a signed 0.1A count.
+func decodeCurrent(edt []byte) (r, t float64, err error) {
+	if len(edt) != 4 {
+		return 0, 0, fmt.Errorf("%w: want 4 bytes, got %d", ErrShortEDT, len(edt))
+	}
+	return float64(int16(binary.BigEndian.Uint16(edt[0:2]))) / 10,
+		float64(int16(binary.BigEndian.Uint16(edt[2:4]))) / 10,
+		nil
+}
+
+// decodeTimestampedCumulative decodes the "regular time" cumulative energy
+// EPCs (0xEA/0xEB): a 6-field date/time followed by a 4-byte reading.
+func decodeTimestampedCumulative(edt []byte) (int, time.Time, error) {
+	if len(edt) != 11 {
+		return 0, time.Time{}, fmt.Errorf("%w: want 11 bytes, got %d", ErrShortEDT, len(edt))
+	}
+	t, err := time.Parse("20060102150405", fmt.Sprintf("%04d%02d%02d%02d%02d%02d",
+		binary.BigEndian.Uint16(edt[0:2]), edt[2], edt[3], edt[4], edt[5], edt[6]))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	cee, err := decodeInt32(edt[7:11])
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return cee, t, nil
+}
+
+// decodeHistoricalArray decodes EPC 0xE2's 48 half-hourly cumulative energy
+// readings for whichever day was last selected via EPC 0xE5.
+func decodeHistoricalArray(edt []byte) ([]int, error) {
+	const points = 48
+	if len(edt) != points*4 {
+		return nil, fmt.Errorf("%w: want %d bytes, got %d", ErrShortEDT, points*4, len(edt))
+	}
+	readings := make([]int, points)
+	for i := range readings {
+		v, err := decodeInt32(edt[i*4 : i*4+4])
+		if err != nil {
+			return nil, err
+		}
+		readings[i] = v
+	}
+	return readings, nil
+}
@@ -0,0 +1,111 @@
+// Package echonet implements the wire format of ECHONET Lite frames and a
+// typed client for the low-voltage smart electric energy meter class
+// (0x028801), independent of the SKSTACK-IP transport that carries them.
+package echonet
+
+import "errors"
+
+var (
+	ErrShortFrame  = errors.New("echonet: frame too short")
+	ErrBadHeader   = errors.New("echonet: unrecognised EHD")
+	ErrShortEDT    = errors.New("echonet: EDT too short for decoder")
+	ErrUnknownCode = errors.New("echonet: unrecognised code")
+)
+
+const (
+	ehd1 = 0x10
+	ehd2 = 0x81
+)
+
+// ECHONET Lite service codes used by SmartMeter.
+const (
+	ESVGet    = 0x62
+	ESVSetC   = 0x61
+	ESVGetRes = 0x72
+	ESVSetRes = 0x71
+)
+
+// EOJ is an ECHONET object code: class group, class, instance.
+type EOJ [3]byte
+
+// ControllerEOJ is the SEOJ a controller identifies itself with when
+// addressing a smart meter.
+var ControllerEOJ = EOJ{0x05, 0xFF, 0x01}
+
+// LowVoltageSmartMeterEOJ is the class/instance of a single low-voltage
+// smart electric energy meter.
+var LowVoltageSmartMeterEOJ = EOJ{0x02, 0x88, 0x01}
+
+// Property is one EPC/EDT pair carried by a Frame.
+type Property struct {
+	EPC byte
+	EDT []byte
+}
+
+// Frame is an ECHONET Lite frame: EHD + TID + SEOJ + DEOJ + ESV + OPC +
+// Properties.
+type Frame struct {
+	TID        uint16
+	SEOJ       EOJ
+	DEOJ       EOJ
+	ESV        byte
+	Properties []Property
+}
+
+// Find returns the property with the given EPC, if the frame carries one.
+func (f Frame) Find(epc byte) (Property, bool) {
+	for _, p := range f.Properties {
+		if p.EPC == epc {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Marshal renders the frame as the raw bytes sent in an SKSENDTO payload.
+func (f Frame) Marshal() []byte {
+	buf := make([]byte, 0, 12+len(f.Properties)*2)
+	buf = append(buf, ehd1, ehd2, byte(f.TID>>8), byte(f.TID))
+	buf = append(buf, f.SEOJ[:]...)
+	buf = append(buf, f.DEOJ[:]...)
+	buf = append(buf, f.ESV, byte(len(f.Properties)))
+	for _, p := range f.Properties {
+		buf = append(buf, p.EPC, byte(len(p.EDT)))
+		buf = append(buf, p.EDT...)
+	}
+	return buf
+}
+
+// Unmarshal parses the raw bytes carried in an ERXUDP notification into a
+// Frame.
+func Unmarshal(data []byte) (Frame, error) {
+	if len(data) < 12 {
+		return Frame{}, ErrShortFrame
+	}
+	if data[0] != ehd1 || data[1] != ehd2 {
+		return Frame{}, ErrBadHeader
+	}
+
+	f := Frame{
+		TID: uint16(data[2])<<8 | uint16(data[3]),
+		ESV: data[10],
+	}
+	copy(f.SEOJ[:], data[4:7])
+	copy(f.DEOJ[:], data[7:10])
+
+	opc := int(data[11])
+	pos := 12
+	for i := 0; i < opc; i++ {
+		if pos+2 > len(data) {
+			return Frame{}, ErrShortFrame
+		}
+		epc, pdc := data[pos], int(data[pos+1])
+		pos += 2
+		if pos+pdc > len(data) {
+			return Frame{}, ErrShortFrame
+		}
+		f.Properties = append(f.Properties, Property{EPC: epc, EDT: append([]byte(nil), data[pos:pos+pdc]...)})
+		pos += pdc
+	}
+	return f, nil
+}
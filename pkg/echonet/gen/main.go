@@ -0,0 +1,190 @@
+// Command gen reads an EPC definition table for the low-voltage smart
+// meter class and emits the typed SmartMeter accessors in
+// pkg/echonet/smartmeter_gen.go. Run it via `go generate ./...` from
+// pkg/echonet rather than invoking it directly.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+type property struct {
+	EPC     string   `yaml:"epc"`
+	Name    string   `yaml:"name"`
+	Decoder string   `yaml:"decoder"`
+	Returns []string `yaml:"returns"`
+	// Fields names each return value when flattened into a batched
+	// request's result struct; defaults to []string{Name} when the
+	// decoder has a single return value.
+	Fields []string `yaml:"fields"`
+}
+
+func (p property) fieldNames() []string {
+	if len(p.Fields) > 0 {
+		return p.Fields
+	}
+	return []string{p.Name}
+}
+
+type batch struct {
+	Name       string   `yaml:"name"`
+	Properties []string `yaml:"properties"`
+}
+
+type table struct {
+	Properties []property `yaml:"properties"`
+	Batched    []batch    `yaml:"batched"`
+}
+
+// batchView is what the template actually walks for a batched request: the
+// batch definition plus the resolved property for each of its EPCs.
+type batchView struct {
+	batch
+	Props []property
+}
+
+var zeroValues = map[string]string{
+	"int":       "0",
+	"float64":   "0",
+	"time.Time": "time.Time{}",
+}
+
+func zero(goType string) string {
+	if v, ok := zeroValues[goType]; ok {
+		return v
+	}
+	return goType + "{}"
+}
+
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func resultNames(returns []string) string {
+	names := make([]string, len(returns))
+	for i := range returns {
+		names[i] = fmt.Sprintf("v%d", i)
+	}
+	return strings.Join(names, ", ")
+}
+
+func main() {
+	in := flag.String("in", "epc.yaml", "EPC definition table")
+	out := flag.String("out", "smartmeter_gen.go", "output Go file")
+	flag.Parse()
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+
+	var t table
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+
+	byEPC := make(map[string]property, len(t.Properties))
+	for _, p := range t.Properties {
+		byEPC[p.EPC] = p
+	}
+
+	batches := make([]batchView, len(t.Batched))
+	for i, b := range t.Batched {
+		props := make([]property, len(b.Properties))
+		for j, epc := range b.Properties {
+			props[j] = byEPC[epc]
+		}
+		batches[i] = batchView{batch: b, Props: props}
+	}
+
+	tmpl := template.Must(template.New("smartmeter").Funcs(template.FuncMap{
+		"resultNames": resultNames,
+		"zero":        zero,
+		"title":       title,
+		"fieldNames":  property.fieldNames,
+	}).Parse(smartmeterTemplate))
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]any{
+		"Properties": t.Properties,
+		"Batched":    batches,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "gen:", err)
+		os.Exit(1)
+	}
+}
+
+const smartmeterTemplate = `// Code generated by gen/main.go from gen/epc.yaml; DO NOT EDIT.
+
+package echonet
+
+import "time"
+
+{{range .Properties}}
+// {{.Name}} reads EPC 0x{{.EPC}} from the meter.
+func (m *SmartMeter) {{.Name}}() ({{range .Returns}}{{.}}, {{end}}error) {
+	res, err := m.get(0x{{.EPC}})
+	if err != nil {
+		return {{range .Returns}}{{zero .}}, {{end}}err
+	}
+	prop, ok := res.Find(0x{{.EPC}})
+	if !ok {
+		return {{range .Returns}}{{zero .}}, {{end}}ErrUnknownCode
+	}
+	{{resultNames .Returns}}, err := decode{{title .Decoder}}(prop.EDT)
+	if err != nil {
+		return {{range .Returns}}{{zero .}}, {{end}}err
+	}
+	return {{resultNames .Returns}}, nil
+}
+{{end}}
+{{range .Batched}}
+// {{.Name}}Result is the decoded response to a {{.Name}} request.
+type {{.Name}}Result struct {
+{{range .Props}}{{$p := .}}{{range $i, $t := .Returns}}	{{index (fieldNames $p) $i}} {{$t}}
+{{end}}{{end}}}
+
+// {{.Name}} reads {{range .Props}}0x{{.EPC}} {{end}}in a single request.
+func (m *SmartMeter) {{.Name}}() ({{.Name}}Result, error) {
+	var result {{.Name}}Result
+	res, err := m.get({{range $i, $p := .Props}}{{if $i}}, {{end}}0x{{$p.EPC}}{{end}})
+	if err != nil {
+		return result, err
+	}
+{{range .Props}}{{$p := .}}	if prop, ok := res.Find(0x{{.EPC}}); ok {
+		{{resultNames .Returns}}, err := decode{{title .Decoder}}(prop.EDT)
+		if err != nil {
+			return result, err
+		}
+{{range $i, $name := (fieldNames $p)}}		result.{{$name}} = v{{$i}}
+{{end}}	} else {
+		return result, ErrUnknownCode
+	}
+{{end}}	return result, nil
+}
+{{end}}
+`
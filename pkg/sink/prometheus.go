@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Low-voltage smart meter EPCs this sink knows how to label a gauge for.
+const (
+	epcInstantaneousPower     = 0xE7
+	epcCumulativeEnergyNormal = 0xEA
+)
+
+// PrometheusSink exposes the most recently recorded measurements as gauges
+// on /metrics, plus a /healthz endpoint, for scraping by Prometheus and
+// checking by an orchestrator.
+type PrometheusSink struct {
+	power     prometheus.Gauge
+	energy    prometheus.Gauge
+	sessionUp prometheus.Gauge
+	server    *http.Server
+}
+
+// NewPrometheusSink builds a sink whose HTTP server listens on addr once
+// ListenAndServe is called.
+func NewPrometheusSink(addr string) *PrometheusSink {
+	s := &PrometheusSink{
+		power: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "smartmeter_instantaneous_power_watts",
+			Help: "Most recently observed instantaneous power draw, in watts.",
+		}),
+		energy: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "smartmeter_cumulative_energy_kwh_total",
+			Help: "Most recently observed cumulative energy reading, in kWh.",
+		}),
+		sessionUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "smartmeter_session_up",
+			Help: "1 if the B-route session is currently authenticated, 0 while reconnecting.",
+		}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s.power, s.energy, s.sessionUp)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	s.server = &http.Server{Addr: addr, Handler: mux}
+
+	return s
+}
+
+// ListenAndServe blocks serving /metrics and /healthz until the server is
+// shut down or fails to start.
+func (s *PrometheusSink) ListenAndServe() error {
+	return s.server.ListenAndServe()
+}
+
+func (s *PrometheusSink) Record(ctx context.Context, m Measurement) error {
+	switch m.EPC {
+	case epcInstantaneousPower:
+		s.power.Set(m.Value)
+	case epcCumulativeEnergyNormal:
+		s.energy.Set(m.Value)
+	}
+	return nil
+}
+
+// SetSessionUp reports whether the B-route session is currently
+// authenticated, so a scraper can tell a live outage from a meter that
+// simply hasn't reported a new value yet.
+func (s *PrometheusSink) SetSessionUp(up bool) {
+	if up {
+		s.sessionUp.Set(1)
+	} else {
+		s.sessionUp.Set(0)
+	}
+}
@@ -0,0 +1,33 @@
+package sink
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// withBackoff retries fn with exponential backoff and jitter until it
+// succeeds, maxAttempts is reached, or ctx is done. It exists so a
+// transient sink failure - a DB blip, a slow exporter - doesn't propagate
+// out of Record and take down whatever is calling it.
+func withBackoff(ctx context.Context, maxAttempts int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == maxAttempts-1 {
+			break
+		}
+
+		backoff := time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+		jitter := time.Duration(rand.Int63n(int64(backoff) / 2))
+		select {
+		case <-time.After(backoff + jitter):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithBackoffSucceedsWithoutRetry(t *testing.T) {
+	calls := 0
+	err := withBackoff(context.Background(), 5, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withBackoff: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestWithBackoffRetriesUntilSuccess(t *testing.T) {
+	errTransient := errors.New("transient")
+	calls := 0
+	err := withBackoff(context.Background(), 5, func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withBackoff: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func TestWithBackoffGivesUpAfterMaxAttempts(t *testing.T) {
+	errPersistent := errors.New("persistent")
+	calls := 0
+	err := withBackoff(context.Background(), 3, func() error {
+		calls++
+		return errPersistent
+	})
+	if err != errPersistent {
+		t.Fatalf("err = %v, want %v", err, errPersistent)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func TestWithBackoffRespectsContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errPersistent := errors.New("persistent")
+	calls := 0
+	err := withBackoff(ctx, 5, func() error {
+		calls++
+		return errPersistent
+	})
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// The first attempt still runs before the backoff sleep checks ctx.
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+}
+
+func TestWithBackoffWaitsBetweenAttempts(t *testing.T) {
+	errTransient := errors.New("transient")
+	start := time.Now()
+	calls := 0
+	_ = withBackoff(context.Background(), 2, func() error {
+		calls++
+		return errTransient
+	})
+	// attempt 0's backoff is 100ms plus up to 50ms jitter; this just checks
+	// withBackoff actually sleeps rather than busy-looping.
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("elapsed = %v, want at least 100ms between attempts", elapsed)
+	}
+}
@@ -0,0 +1,32 @@
+// Package sink ships Measurement off to wherever an operator wants it
+// stored or scraped, independent of how it was read off the meter.
+package sink
+
+import (
+	"context"
+	"time"
+)
+
+// Measurement is one observed value read from a meter: an EPC's decoded
+// reading at a point in time.
+type Measurement struct {
+	MeterAddr string
+	EPC       byte
+	Timestamp time.Time
+	Value     float64
+	Unit      string
+}
+
+// Sink records measurements somewhere: stdout, Prometheus, a SQL database,
+// ... Record should tolerate being called concurrently from multiple
+// goroutines.
+type Sink interface {
+	Record(ctx context.Context, m Measurement) error
+}
+
+// SessionAware is implemented by sinks that can mark served metrics stale
+// while the connection to the meter is down, rather than silently serving
+// the last value recorded before an outage.
+type SessionAware interface {
+	SetSessionUp(up bool)
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// bindType is the placeholder style a SQL driver expects, akin to sqlx's
+// BindType mapping from driver name to bind style.
+type bindType int
+
+const (
+	bindQuestion bindType = iota // ?, ?, ...     (sqlite3, mysql)
+	bindDollar                   // $1, $2, ...   (postgres, pgx)
+)
+
+func bindTypeForDriver(driverName string) bindType {
+	switch driverName {
+	case "postgres", "pgx":
+		return bindDollar
+	default:
+		return bindQuestion
+	}
+}
+
+// rebind rewrites a query written with "?" placeholders into the style the
+// target driver expects.
+func rebind(bind bindType, query string) string {
+	if bind != bindDollar {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLSink inserts measurements into a "measurements" table via
+// database/sql, working with whatever driver the caller opened db with.
+type SQLSink struct {
+	db          *sql.DB
+	bind        bindType
+	insertQuery string
+	maxAttempts int
+}
+
+// NewSQLSink migrates the measurements table if needed and returns a sink
+// that inserts into it. driverName picks the placeholder style (see
+// bindTypeForDriver); it does not need to match db's driver exactly, only
+// its placeholder dialect.
+func NewSQLSink(ctx context.Context, db *sql.DB, driverName string) (*SQLSink, error) {
+	const schema = `
+CREATE TABLE IF NOT EXISTS measurements (
+	meter_addr TEXT      NOT NULL,
+	epc        INTEGER   NOT NULL,
+	ts         TIMESTAMP NOT NULL,
+	value      REAL      NOT NULL,
+	unit       TEXT      NOT NULL
+)`
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("sink: migrate measurements table: %w", err)
+	}
+
+	bind := bindTypeForDriver(driverName)
+	return &SQLSink{
+		db:   db,
+		bind: bind,
+		insertQuery: rebind(bind, `INSERT INTO measurements
+			(meter_addr, epc, ts, value, unit) VALUES (?, ?, ?, ?, ?)`),
+		maxAttempts: 5,
+	}, nil
+}
+
+func (s *SQLSink) Record(ctx context.Context, m Measurement) error {
+	return withBackoff(ctx, s.maxAttempts, func() error {
+		_, err := s.db.ExecContext(ctx, s.insertQuery, m.MeterAddr, m.EPC, m.Timestamp, m.Value, m.Unit)
+		return err
+	})
+}
@@ -0,0 +1,56 @@
+package sink
+
+import "testing"
+
+func TestBindTypeForDriver(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   bindType
+	}{
+		{"postgres", bindDollar},
+		{"pgx", bindDollar},
+		{"sqlite3", bindQuestion},
+		{"mysql", bindQuestion},
+		{"", bindQuestion},
+	}
+	for _, tt := range tests {
+		if got := bindTypeForDriver(tt.driver); got != tt.want {
+			t.Errorf("bindTypeForDriver(%q) = %v, want %v", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		bind  bindType
+		query string
+		want  string
+	}{
+		{
+			name:  "question mark driver leaves query untouched",
+			bind:  bindQuestion,
+			query: "INSERT INTO t (a, b) VALUES (?, ?)",
+			want:  "INSERT INTO t (a, b) VALUES (?, ?)",
+		},
+		{
+			name:  "dollar driver numbers placeholders in order",
+			bind:  bindDollar,
+			query: "INSERT INTO t (a, b) VALUES (?, ?)",
+			want:  "INSERT INTO t (a, b) VALUES ($1, $2)",
+		},
+		{
+			name:  "dollar driver with no placeholders",
+			bind:  bindDollar,
+			query: "SELECT 1",
+			want:  "SELECT 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rebind(tt.bind, tt.query); got != tt.want {
+				t.Errorf("rebind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
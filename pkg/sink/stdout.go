@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// StdoutSink writes each Measurement as one JSON line, for piping into
+// jq/log aggregators or just eyeballing during development.
+type StdoutSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+func NewStdoutSink(w io.Writer) *StdoutSink {
+	return &StdoutSink{w: w, enc: json.NewEncoder(w)}
+}
+
+func (s *StdoutSink) Record(ctx context.Context, m Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(m)
+}